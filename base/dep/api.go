@@ -78,7 +78,8 @@ type Decl struct {
 	Node  ast.Node // nil for multiple const or var declarations in a single *ast.ValueSpec - in such case, see Extra
 	Deps  []string // names of types, constants and variables used in Node's declaration
 	Pos   token.Pos
-	Iota  int // for constants, value of iota to use
+	Iota  int    // for constants, value of iota to use
+	Recv  string // for Method decls: name of the receiver type, e.g. "Foo" or "*Foo"
 	Extra *Extra
 }
 
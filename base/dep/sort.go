@@ -0,0 +1,219 @@
+/*
+ * gomacro - A Go interpreter with Lisp-like macros
+ *
+ * Copyright (C) 2017-2022 Massimiliano Ghilardi
+ *
+ *     This program is free software: you can redistribute it and/or modify
+ *     it under the terms of the GNU Lesser General Public License as published
+ *     by the Free Software Foundation, either version 3 of the License, or
+ *     (at your option) any later version.
+ *
+ *     This program is distributed in the hope that it will be useful,
+ *     but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *     GNU Lesser General Public License for more details.
+ *
+ *     You should have received a copy of the GNU Lesser General Public License
+ *     along with this program.  If not, see <https://www.gnu.org/licenses/lgpl>.
+ *
+ *
+ * sort.go
+ *
+ *  Created on: Mar 04, 2022
+ *      Author: Massimiliano Ghilardi
+ */
+
+package dep
+
+import (
+	"go/ast"
+	"sort"
+)
+
+// receiverName extracts the base type name from a method receiver such as
+// "Foo" or "*Foo", stripping the leading "*" used for pointer receivers.
+func receiverName(recv string) string {
+	if len(recv) != 0 && recv[0] == '*' {
+		return recv[1:]
+	}
+	return recv
+}
+
+// appendUnique appends name to deps unless it is already present.
+func appendUnique(deps []string, name string) []string {
+	for _, dep := range deps {
+		if dep == name {
+			return deps
+		}
+	}
+	return append(deps, name)
+}
+
+// isInterface reports whether decl declares an interface type.
+func isInterface(decl *Decl) bool {
+	spec, ok := decl.Node.(*ast.TypeSpec)
+	if !ok {
+		return false
+	}
+	_, ok = spec.Type.(*ast.InterfaceType)
+	return ok
+}
+
+// identsIn collects the names of every *ast.Ident appearing in node.
+func identsIn(node ast.Node) []string {
+	if node == nil {
+		return nil
+	}
+	var names []string
+	ast.Inspect(node, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			names = append(names, id.Name)
+		}
+		return true
+	})
+	return names
+}
+
+// resolveEdges rewrites decls' Deps in place so that:
+//
+//   - a Method decl depends on its receiver Type, instead of the other
+//     way around: a method declared textually before its receiver type
+//     no longer looks like a dependency of that type, which used to
+//     force spurious cycles or a wrong compile order;
+//   - every interface Type gains an implicit dependency on every named
+//     type referenced by its embedded interfaces or method signatures,
+//     so an interface is never scheduled before a type it mentions.
+//
+// It returns decls, for convenience.
+func resolveEdges(decls DeclMap) DeclMap {
+	for _, decl := range decls {
+		if decl.Kind != Method || len(decl.Recv) == 0 {
+			continue
+		}
+		name := receiverName(decl.Recv)
+		if _, ok := decls[name]; ok {
+			decl.Deps = appendUnique(decl.Deps, name)
+		}
+	}
+	for name, decl := range decls {
+		if decl.Kind != Type || !isInterface(decl) {
+			continue
+		}
+		for _, dep := range identsIn(decl.Node) {
+			if dep == name {
+				continue
+			}
+			if _, ok := decls[dep]; ok {
+				decl.Deps = appendUnique(decl.Deps, dep)
+			}
+		}
+	}
+	return decls
+}
+
+// tarjan holds the state of a single run of Tarjan's strongly-connected
+// components algorithm over a Sorter's declarations.
+type tarjan struct {
+	decls   DeclMap
+	index   map[string]int
+	lowlink map[string]int
+	onstack map[string]bool
+	stack   []string
+	counter int
+	groups  []DeclList
+}
+
+func (t *tarjan) visit(name string) {
+	if _, seen := t.index[name]; seen {
+		return
+	}
+	decl, ok := t.decls[name]
+	if !ok {
+		return // dependency on an unknown or external name: ignore it
+	}
+	t.index[name] = t.counter
+	t.lowlink[name] = t.counter
+	t.counter++
+	t.stack = append(t.stack, name)
+	t.onstack[name] = true
+
+	for _, dep := range decl.Deps {
+		if dep == name {
+			continue
+		}
+		if _, seen := t.index[dep]; !seen {
+			if _, ok := t.decls[dep]; !ok {
+				continue
+			}
+			t.visit(dep)
+			if t.lowlink[dep] < t.lowlink[name] {
+				t.lowlink[name] = t.lowlink[dep]
+			}
+		} else if t.onstack[dep] {
+			if t.index[dep] < t.lowlink[name] {
+				t.lowlink[name] = t.index[dep]
+			}
+		}
+	}
+
+	if t.lowlink[name] != t.index[name] {
+		return
+	}
+	var group DeclList
+	for {
+		n := len(t.stack) - 1
+		top := t.stack[n]
+		t.stack = t.stack[:n]
+		t.onstack[top] = false
+		group = append(group, t.decls[top])
+		if top == name {
+			break
+		}
+	}
+	t.groups = append(t.groups, group)
+}
+
+// SortGroups groups s's declarations into strongly-connected components of
+// the dependency graph built by resolveEdges, using Tarjan's algorithm.
+// Every DeclList it returns can - and, if it has more than one element,
+// must - be compiled in one shot: its declarations depend on each other
+// either directly or through a longer cycle, the common case being
+// mutually recursive types and functions. A method only depends on its
+// receiver type, not the other way around, so a type and its methods are
+// in different groups. Groups are returned in dependency order: a group never
+// depends on a group that follows it.
+func (s *Sorter) SortGroups() []DeclList {
+	decls := resolveEdges(s.Loader.Decls)
+	names := make([]string, 0, len(decls))
+	for name := range decls {
+		names = append(names, name)
+	}
+	// iterate in a deterministic order: map iteration order is randomized,
+	// and Tarjan's output order for independent components depends on it.
+	sort.Strings(names)
+
+	t := &tarjan{
+		decls:   decls,
+		index:   make(map[string]int),
+		lowlink: make(map[string]int),
+		onstack: make(map[string]bool),
+	}
+	for _, name := range names {
+		t.visit(name)
+	}
+	return t.groups
+}
+
+// Sort returns s's declarations in a valid compile order: every
+// declaration follows all the declarations it depends on outside its own
+// mutually-recursive group. Declarations
+// that belong to the same mutually-recursive group (see SortGroups) keep
+// their SortGroups relative order, i.e. are adjacent in the result.
+func (s *Sorter) Sort() DeclList {
+	groups := s.SortGroups()
+	list := make(DeclList, 0, len(s.Loader.Decls))
+	for _, group := range groups {
+		list = append(list, group...)
+	}
+	return list
+}
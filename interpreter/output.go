@@ -33,6 +33,9 @@ import (
 	"io"
 	r "reflect"
 	"sort"
+	"strings"
+
+	"github.com/cosmos72/gomacro/base/dep"
 )
 
 type RuntimeError struct {
@@ -214,9 +217,10 @@ func (f FileSet) nodeToPrintable(node ast.Node) interface{} {
 
 func (f FileSet) showHelp(out io.Writer) {
 	fmt.Fprint(out, `// interpreter commands:
-:env    show available functions, variables and constants
-:help   show this help
-:quit   quit the interpreter
+:env             show available functions, variables and constants
+:help            show this help
+:quit            quit the interpreter
+:rename OLD NEW  rename a top-level binding, rewriting cached declarations too
 `)
 }
 
@@ -235,4 +239,217 @@ func (env *Env) showEnv(out io.Writer) {
 		fmt.Fprintf(out, "%s%s = ", k, spaces15[n:])
 		env.FprintValue(out, binds[k])
 	}
-}
\ No newline at end of file
+}
+
+// renameResult summarizes the effect of a successful :rename command.
+type renameResult struct {
+	Old     string
+	New     string
+	Touched []string // names of the declarations whose Node or Deps changed
+}
+
+// runRename implements the REPL's ":rename OLD NEW" command: it parses
+// 'args', renames OLD to NEW in env and scope, and reports the outcome to
+// out - analogous to what gorename does for source files.
+func runRename(out io.Writer, env *Env, scope *dep.Scope, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 {
+		fmt.Fprint(out, "// :rename expects exactly two arguments: OLD NEW\n")
+		return
+	}
+	result, err := env.Rename(scope, fields[0], fields[1])
+	if err != nil {
+		fmt.Fprintf(out, "// %v\n", err)
+		return
+	}
+	fmt.Fprintf(out, "// renamed %q to %q, touched %d declaration(s):\n", result.Old, result.New, len(result.Touched))
+	env.FprintValue(out, r.ValueOf(*result))
+}
+
+// RunMetaCmd recognizes and executes a single REPL meta-command line -
+// any of the commands listed by showHelp: ":env", ":help", ":quit" or
+// ":rename OLD NEW". It writes the command's output to p.Stdout and
+// reports whether 'line' was a recognized meta-command, and, if so,
+// whether it requests that the REPL quit.
+func (p *Interpreter) RunMetaCmd(env *Env, scope *dep.Scope, line string) (recognized, quit bool) {
+	cmd, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+	switch cmd {
+	case ":env":
+		env.showEnv(p.Stdout)
+	case ":help":
+		p.showHelp(p.Stdout)
+	case ":quit":
+		quit = true
+	case ":rename":
+		runRename(p.Stdout, env, scope, args)
+	default:
+		return false, false
+	}
+	return true, quit
+}
+
+// Rename renames the top-level binding 'oldname' to 'newname': it moves
+// the value in env.Binds, then rewrites every cached *dep.Decl.Node in
+// scope that references oldname (via ast.Inspect over *ast.Ident, the
+// same approach gorename uses on source files) and updates each such
+// decl's Deps slice to match. An identifier in *ast.SelectorExpr.Sel
+// position - a.Foo's "Foo" - is a field or method selector, not a
+// reference to a top-level binding, and is left untouched even when its
+// name happens to match oldname.
+//
+// Rename refuses to touch oldname if it is shadowed by a nested
+// declaration - e.g. a local variable with the same name inside a
+// function body - unless that shadow is renamed too: silently renaming
+// only the outer binding while leaving shadowed uses alone would change
+// what those uses refer to.
+func (env *Env) Rename(scope *dep.Scope, oldname, newname string) (*renameResult, error) {
+	if oldname == newname {
+		return nil, fmt.Errorf(":rename: old and new name are both %q", oldname)
+	}
+	if _, ok := env.Binds[newname]; ok {
+		return nil, fmt.Errorf(":rename: %q is already bound, refusing to overwrite it", newname)
+	}
+	val, ok := env.Binds[oldname]
+	if !ok {
+		return nil, fmt.Errorf(":rename: %q is not bound in the current environment", oldname)
+	}
+
+	topObj := topLevelObject(scope, oldname)
+	if shadowedIn := findShadows(scope, oldname, topObj); len(shadowedIn) != 0 {
+		return nil, fmt.Errorf(":rename: %q is shadowed inside %v, rename the shadow(s) there first",
+			oldname, shadowedIn)
+	}
+
+	result := &renameResult{Old: oldname, New: newname}
+	for name, decl := range scope.Decls {
+		if decl.Node == nil {
+			continue
+		}
+		touched := false
+		var rewrite func(n ast.Node) bool
+		rewrite = func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				// sel.Sel is a field/method selector, not a reference to
+				// oldname - only descend into the qualifier.
+				ast.Inspect(sel.X, rewrite)
+				return false
+			}
+			if id, ok := n.(*ast.Ident); ok && id.Name == oldname {
+				id.Name = newname
+				touched = true
+			}
+			return true
+		}
+		ast.Inspect(decl.Node, rewrite)
+		if !touched {
+			continue
+		}
+		for i, name := range decl.Deps {
+			if name == oldname {
+				decl.Deps[i] = newname
+			}
+		}
+		result.Touched = append(result.Touched, name)
+	}
+	sort.Strings(result.Touched)
+
+	delete(env.Binds, oldname)
+	env.Binds[newname] = val
+	return result, nil
+}
+
+// topLevelObject returns the *ast.Object identifying oldname's top-level
+// declaration in scope, or nil if it cannot be determined - in which case
+// findShadows conservatively treats every declaring occurrence of oldname
+// with a non-nil Obj as a possible shadow.
+func topLevelObject(scope *dep.Scope, oldname string) *ast.Object {
+	decl, ok := scope.Decls[oldname]
+	if !ok || decl.Node == nil {
+		return nil
+	}
+	var obj *ast.Object
+	ast.Inspect(decl.Node, func(n ast.Node) bool {
+		if obj != nil {
+			return false
+		}
+		if id, ok := n.(*ast.Ident); ok && id.Name == oldname && id.Obj != nil {
+			obj = id.Obj
+			return false
+		}
+		return true
+	})
+	return obj
+}
+
+// findShadows returns the sorted names of every top-level declaration in
+// scope that introduces a *new* binding named oldname - a function
+// parameter or result, a local var/const/type, or a range variable -
+// distinct from topObj. Such a binding shadows the top-level binding
+// being renamed and must be renamed first.
+//
+// This only looks at declaring occurrences of oldname, not plain
+// *ast.Ident references to it: each decl.Node is parsed independently,
+// so a decl that merely calls or reads the top-level binding (e.g. one
+// cached decl referring to another) never shares an *ast.Object instance
+// with topObj even though it refers to the very same binding - comparing
+// Obj identity on every Ident would misclassify that reference as a
+// shadow.
+func findShadows(scope *dep.Scope, oldname string, topObj *ast.Object) []string {
+	var shadowed []string
+	for name, decl := range scope.Decls {
+		if decl.Node == nil {
+			continue
+		}
+		found := false
+		ast.Inspect(decl.Node, func(n ast.Node) bool {
+			declares := false
+			switch n := n.(type) {
+			case *ast.Field: // function parameter or result
+				declares = declaresIdent(n.Names, oldname, topObj)
+			case *ast.ValueSpec: // var or const
+				declares = declaresIdent(n.Names, oldname, topObj)
+			case *ast.TypeSpec:
+				declares = n.Name.Name == oldname && n.Name.Obj != topObj
+			case *ast.AssignStmt:
+				if n.Tok == token.DEFINE {
+					declares = declaresExpr(n.Lhs, oldname, topObj)
+				}
+			case *ast.RangeStmt:
+				if n.Tok == token.DEFINE {
+					declares = declaresExpr([]ast.Expr{n.Key, n.Value}, oldname, topObj)
+				}
+			}
+			if declares {
+				found = true
+			}
+			return true
+		})
+		if found {
+			shadowed = append(shadowed, name)
+		}
+	}
+	sort.Strings(shadowed)
+	return shadowed
+}
+
+// declaresIdent reports whether any of 'names' declares oldname as a new
+// binding distinct from topObj.
+func declaresIdent(names []*ast.Ident, oldname string, topObj *ast.Object) bool {
+	for _, id := range names {
+		if id.Name == oldname && id.Obj != topObj {
+			return true
+		}
+	}
+	return false
+}
+
+// declaresExpr is declaresIdent for the plain identifiers among 'exprs',
+// as found on the left-hand side of a ":=" assignment or range statement.
+func declaresExpr(exprs []ast.Expr, oldname string, topObj *ast.Object) bool {
+	for _, e := range exprs {
+		if id, ok := e.(*ast.Ident); ok && id.Name == oldname && id.Obj != topObj {
+			return true
+		}
+	}
+	return false
+}
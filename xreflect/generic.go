@@ -0,0 +1,383 @@
+/*
+ * gomacro - A Go interpreter with Lisp-like macros
+ *
+ * Copyright (C) 2017-2022 Massimiliano Ghilardi
+ *
+ *     This program is free software you can redistribute it and/or modify
+ *     it under the terms of the GNU General Public License as published by
+ *     the Free Software Foundation, either version 3 of the License, or
+ *     (at your option) any later version.
+ *
+ *     This program is distributed in the hope that it will be useful,
+ *     but WITHOUT ANY WARRANTY; without even the implied warranty of
+ *     MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ *     GNU General Public License for more details.
+ *
+ *     You should have received a copy of the GNU General Public License
+ *     along with this program.  If not, see <http//www.gnu.org/licenses/>.
+ *
+ * generic.go
+ *
+ *  Created on Mar 02, 2022
+ *      Author Massimiliano Ghilardi
+ */
+
+package xreflect
+
+import (
+	"go/token"
+	"go/types"
+	"reflect"
+)
+
+// reflect.Type has no notion of a type parameter: every uninstantiated
+// occurrence of a TypeParam is approximated on the rtype side by the
+// empty interface, and is replaced by a genuine reflect.Type only once
+// Instantiate() substitutes concrete types for every type parameter.
+var typeParamRType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// NewTypeParam creates a fresh type parameter named 'name', constrained by
+// 'constraint' (usually an interface type - the predeclared "any" and
+// "comparable" constraints, or a user-defined constraint interface).
+// The result can be used wherever a Type is expected: as one of the
+// 'tparams' passed to GenericFuncOf/GenericMethodOf, and anywhere inside
+// the function's 'in' and 'out' types, eventually nested inside a slice,
+// map, pointer or another function type.
+func NewTypeParam(name string, constraint Type) Type {
+	v := universe
+	if constraint != nil {
+		v = constraint.Universe()
+	}
+	return v.NewTypeParam(name, constraint)
+}
+
+func (v *Universe) NewTypeParam(name string, constraint Type) Type {
+	var gconstraint types.Type
+	if constraint != nil {
+		gconstraint = toGoType(constraint)
+	} else {
+		gconstraint = types.Universe.Lookup("any").Type()
+	}
+	tname := types.NewTypeName(token.NoPos, nil, name, nil)
+	gtype := types.NewTypeParam(tname, gconstraint)
+	return v.MakeType(gtype, typeParamRType)
+}
+
+// toGoType extracts the go/types.Type wrapped by an xreflect.Type.
+func toGoType(t Type) types.Type {
+	if t == nil {
+		return nil
+	}
+	return t.(*xtype).gtype
+}
+
+// typeParamName reports the name of t's underlying type parameter, if t
+// was created (directly, not nested) by NewTypeParam.
+func typeParamName(t Type) (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	tp, ok := t.(*xtype).gtype.(*types.TypeParam)
+	if !ok {
+		return "", false
+	}
+	return tp.Obj().Name(), true
+}
+
+// sigTypeParams returns a signature's type parameters, whether they came
+// from an ordinary generic function (TypeParams) or a method on a generic
+// receiver type (RecvTypeParams) - go/types never populates both lists on
+// the same signature, see GenericMethodOf.
+func sigTypeParams(sig *types.Signature) *types.TypeParamList {
+	if tparams := sig.RecvTypeParams(); tparams.Len() != 0 {
+		return tparams
+	}
+	return sig.TypeParams()
+}
+
+// IsGeneric reports whether a function or method type still has
+// uninstantiated type parameters, i.e. represents a Go 1.18+ generic
+// declaration such as func Map[T, U any](s []T, f func(T) U) []U.
+// It panics if t's Kind is not Func.
+func (t *xtype) IsGeneric() bool {
+	if t.Kind() != reflect.Func {
+		xerrorf(t, "IsGeneric of non-func type %v", t)
+	}
+	sig, ok := t.gtype.(*types.Signature)
+	return ok && sigTypeParams(sig).Len() != 0
+}
+
+// NumTypeParams returns a function or method type's type parameter count.
+// It is zero for ordinary, non-generic functions. It panics if t's Kind
+// is not Func.
+func (t *xtype) NumTypeParams() int {
+	if t.Kind() != reflect.Func {
+		xerrorf(t, "NumTypeParams of non-func type %v", t)
+	}
+	sig, ok := t.gtype.(*types.Signature)
+	if !ok {
+		return 0
+	}
+	return sigTypeParams(sig).Len()
+}
+
+// TypeParams returns a generic function or method type's type parameters,
+// in declaration order. It returns nil if t is not generic. It panics if
+// t's Kind is not Func.
+func (t *xtype) TypeParams() []Type {
+	if t.Kind() != reflect.Func {
+		xerrorf(t, "TypeParams of non-func type %v", t)
+	}
+	sig, ok := t.gtype.(*types.Signature)
+	if !ok {
+		return nil
+	}
+	tparams := sigTypeParams(sig)
+	n := tparams.Len()
+	if n == 0 {
+		return nil
+	}
+	ret := make([]Type, n)
+	for i := 0; i < n; i++ {
+		ret[i] = t.universe.MakeType(tparams.At(i), typeParamRType)
+	}
+	return ret
+}
+
+// GenericFuncOf is FuncOf's generic counterpart: it builds the type of a
+// Go 1.18+ generic function such as func Map[T, U any](s []T, f func(T) U) []U.
+// 'tparams' must be Types created with NewTypeParam, and may appear -
+// possibly nested inside a slice, map, pointer or function type - anywhere
+// in 'in' and 'out'. Call Instantiate on the result to obtain the
+// monomorphic Type of a specific instantiation.
+func GenericFuncOf(tparams []Type, in []Type, out []Type, variadic bool) Type {
+	return GenericMethodOf(nil, tparams, in, out, variadic)
+}
+
+func (v *Universe) GenericFuncOf(tparams []Type, in []Type, out []Type, variadic bool) Type {
+	return v.GenericMethodOf(nil, tparams, in, out, variadic)
+}
+
+// GenericMethodOf is MethodOf's generic counterpart. See GenericFuncOf.
+func GenericMethodOf(recv Type, tparams []Type, in []Type, out []Type, variadic bool) Type {
+	v := universe
+	if recv != nil {
+		v = recv.Universe()
+	} else if len(tparams) != 0 && tparams[0] != nil {
+		v = tparams[0].Universe()
+	} else if len(in) != 0 && in[0] != nil {
+		v = in[0].Universe()
+	} else if len(out) != 0 && out[0] != nil {
+		v = out[0].Universe()
+	}
+	return v.GenericMethodOf(recv, tparams, in, out, variadic)
+}
+
+func (v *Universe) GenericMethodOf(recv Type, tparams []Type, in []Type, out []Type, variadic bool) Type {
+	if len(tparams) == 0 {
+		// no type parameters: behaves exactly like MethodOf
+		return v.MethodOf(recv, in, out, variadic)
+	}
+	gtparams := make([]*types.TypeParam, len(tparams))
+	for i, tp := range tparams {
+		if _, ok := typeParamName(tp); !ok {
+			xerrorf(nil, "GenericMethodOf: tparams[%d] = <%v> was not created by NewTypeParam", i, tp)
+		}
+		gtparams[i] = toGoType(tp).(*types.TypeParam)
+	}
+	gin := toGoTuple(in)
+	gout := toGoTuple(out)
+	rin := toReflectTypes(in)
+	rout := toReflectTypes(out)
+	var grecv *types.Var
+	if recv != nil {
+		rin = append([]reflect.Type{recv.ReflectType()}, rin...)
+		grecv = toGoParam(recv)
+	}
+	// go/types forbids a signature from having both a receiver and its own
+	// method type parameters: a method on a generic receiver type instead
+	// carries the receiver's type parameters in the recvTypeParams slot.
+	var recvTypeParams, funcTypeParams []*types.TypeParam
+	if grecv != nil {
+		recvTypeParams = gtparams
+	} else {
+		funcTypeParams = gtparams
+	}
+	gtype := types.NewSignatureType(grecv, recvTypeParams, funcTypeParams, gin, gout, variadic)
+	if grecv != nil {
+		debugf("xreflect.GenericMethodOf: recv = <%v>, method = <%v> with recv = <%v>", grecv, gtype, gtype.Recv())
+	}
+	return v.MakeType(
+		gtype,
+		reflect.FuncOf(rin, rout, variadic),
+	)
+}
+
+// Instantiate instantiates a generic function or method type, substituting
+// each of its type parameters - in declaration order - with the
+// corresponding Type in 'args'. It returns a plain, non-generic function
+// type with a genuine, monomorphic reflect.Type. It panics if t is not
+// generic, or if len(args) does not equal t.NumTypeParams().
+func (t *xtype) Instantiate(args ...Type) Type {
+	if !t.IsGeneric() {
+		xerrorf(t, "Instantiate of non-generic type %v", t)
+	}
+	tparams := t.TypeParams()
+	if len(args) != len(tparams) {
+		xerrorf(t, "Instantiate: type %v expects %d type arguments, found %d", t, len(tparams), len(args))
+	}
+	bind := make(map[string]Type, len(args))
+	for i, tp := range tparams {
+		name, _ := typeParamName(tp)
+		bind[name] = args[i]
+	}
+	n := t.NumIn()
+	in := make([]Type, n)
+	for i := 0; i < n; i++ {
+		in[i] = substType(t.In(i), bind)
+	}
+	n = t.NumOut()
+	out := make([]Type, n)
+	for i := 0; i < n; i++ {
+		out[i] = substType(t.Out(i), bind)
+	}
+	var recv Type
+	if t.IsMethod() {
+		recv = substType(t.Recv(), bind)
+	}
+	variadic := t.rtype.IsVariadic()
+	return t.universe.MethodOf(recv, in, out, variadic)
+}
+
+// substType rebuilds 'orig', replacing every direct or nested occurrence of
+// a type parameter bound in 'bind' with its bound Type. Types that contain
+// no type parameter are returned unchanged.
+func substType(orig Type, bind map[string]Type) Type {
+	if name, ok := typeParamName(orig); ok {
+		if bound, ok := bind[name]; ok {
+			return bound
+		}
+		return orig
+	}
+	if named, ok := orig.(*xtype).gtype.(*types.Named); ok && named.TypeArgs().Len() == 0 {
+		// a non-generic named type contains no type parameter
+		return orig
+	}
+	switch orig.Kind() {
+	case reflect.Ptr:
+		return PtrTo(substType(orig.Elem(), bind))
+	case reflect.Slice:
+		return SliceOf(substType(orig.Elem(), bind))
+	case reflect.Array:
+		return ArrayOf(orig.Len(), substType(orig.Elem(), bind))
+	case reflect.Map:
+		return MapOf(substType(orig.Key(), bind), substType(orig.Elem(), bind))
+	case reflect.Chan:
+		return ChanOf(orig.ChanDir(), substType(orig.Elem(), bind))
+	case reflect.Func:
+		if orig.(*xtype).IsGeneric() {
+			// a nested generic function: leave it alone, it is
+			// instantiated on its own by a separate Instantiate call.
+			return orig
+		}
+		n := orig.NumIn()
+		in := make([]Type, n)
+		for i := 0; i < n; i++ {
+			in[i] = substType(orig.In(i), bind)
+		}
+		n = orig.NumOut()
+		out := make([]Type, n)
+		for i := 0; i < n; i++ {
+			out[i] = substType(orig.Out(i), bind)
+		}
+		return FuncOf(in, out, orig.(*xtype).IsVariadic())
+	default:
+		return orig
+	}
+}
+
+// Unify implements a simple Robinson-style unifier: it walks 'pattern' and
+// 'concrete' in parallel, binding each type parameter found in 'pattern'
+// to the corresponding part of 'concrete' in 'bind', and reports whether
+// unification succeeded. It fails as soon as it finds mismatched kinds, or
+// a type parameter that would need two different bindings.
+//
+// This is exactly what an interpreter call site needs to infer a generic
+// function's type arguments from the types of the arguments actually
+// passed, without implementing full Hindley-Milner inference.
+func Unify(pattern, concrete Type, bind map[string]Type) bool {
+	if pattern == nil || concrete == nil {
+		return pattern == concrete
+	}
+	if name, ok := typeParamName(pattern); ok {
+		if prev, ok := bind[name]; ok {
+			return prev.ReflectType() == concrete.ReflectType()
+		}
+		bind[name] = concrete
+		return true
+	}
+	if pattern.Kind() != concrete.Kind() {
+		return false
+	}
+	switch pattern.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		return Unify(pattern.Elem(), concrete.Elem(), bind)
+	case reflect.Array:
+		return pattern.Len() == concrete.Len() && Unify(pattern.Elem(), concrete.Elem(), bind)
+	case reflect.Chan:
+		return pattern.ChanDir() == concrete.ChanDir() && Unify(pattern.Elem(), concrete.Elem(), bind)
+	case reflect.Map:
+		return Unify(pattern.Key(), concrete.Key(), bind) && Unify(pattern.Elem(), concrete.Elem(), bind)
+	case reflect.Func:
+		if pattern.NumIn() != concrete.NumIn() || pattern.NumOut() != concrete.NumOut() {
+			return false
+		}
+		for i := 0; i < pattern.NumIn(); i++ {
+			if !Unify(pattern.In(i), concrete.In(i), bind) {
+				return false
+			}
+		}
+		for i := 0; i < pattern.NumOut(); i++ {
+			if !Unify(pattern.Out(i), concrete.Out(i), bind) {
+				return false
+			}
+		}
+		return true
+	default:
+		return pattern.ReflectType() == concrete.ReflectType()
+	}
+}
+
+// InstantiateFromArgs infers t's type arguments by unifying each of t's
+// declared parameter types against the Type of the actual argument passed
+// at a call site, then instantiates t with the inferred types. This is the
+// entry point interpreter call sites should use for a generic callee
+// invoked without explicit type arguments, e.g. Map(xs, f) rather than
+// Map[int, string](xs, f). It panics if inference fails for any type
+// parameter, or if len(argTypes) does not match t.NumIn().
+func (t *xtype) InstantiateFromArgs(argTypes ...Type) Type {
+	if !t.IsGeneric() {
+		xerrorf(t, "InstantiateFromArgs of non-generic type %v", t)
+	}
+	if len(argTypes) != t.NumIn() {
+		xerrorf(t, "InstantiateFromArgs: type %v expects %d arguments, found %d", t, t.NumIn(), len(argTypes))
+	}
+	bind := make(map[string]Type)
+	for i, argt := range argTypes {
+		if !Unify(t.In(i), argt, bind) {
+			xerrorf(t, "InstantiateFromArgs: cannot unify parameter %d (%v) of %v with argument type %v",
+				i, t.In(i), t, argt)
+		}
+	}
+	tparams := t.TypeParams()
+	args := make([]Type, len(tparams))
+	for i, tp := range tparams {
+		name, _ := typeParamName(tp)
+		bound, ok := bind[name]
+		if !ok {
+			xerrorf(t, "InstantiateFromArgs: could not infer type parameter %s of %v", name, t)
+		}
+		args[i] = bound
+	}
+	return t.Instantiate(args...)
+}